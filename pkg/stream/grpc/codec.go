@@ -0,0 +1,57 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// frameHeaderLen is the length of a gRPC length-prefixed message frame
+// header: a 1-byte compression flag followed by a 4-byte big-endian length.
+const frameHeaderLen = 5
+
+// ErrShortFrameHeader is returned by DecodeFrameHeader when fewer than
+// frameHeaderLen bytes are available.
+var ErrShortFrameHeader = errors.New("grpc: short frame header")
+
+// EncodeFrame wraps payload in a gRPC length-prefixed message frame.
+// compressed marks bit 0 of the header to indicate payload was compressed
+// with the encoding named in the grpc-encoding header.
+func EncodeFrame(compressed bool, payload []byte) []byte {
+	frame := make([]byte, frameHeaderLen+len(payload))
+
+	if compressed {
+		frame[0] = 1
+	}
+	binary.BigEndian.PutUint32(frame[1:frameHeaderLen], uint32(len(payload)))
+	copy(frame[frameHeaderLen:], payload)
+
+	return frame
+}
+
+// DecodeFrameHeader parses the 5-byte header of a gRPC message frame,
+// reporting whether the payload is compressed and how many payload bytes
+// follow.
+func DecodeFrameHeader(hdr []byte) (compressed bool, length uint32, err error) {
+	if len(hdr) < frameHeaderLen {
+		return false, 0, ErrShortFrameHeader
+	}
+
+	return hdr[0] != 0, binary.BigEndian.Uint32(hdr[1:frameHeaderLen]), nil
+}
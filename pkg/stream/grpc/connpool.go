@@ -0,0 +1,85 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package grpc proxies gRPC traffic. It does not manage its own HTTP/2
+// connections: NewConnPool wraps the pkg/stream/http2 conn pool so gRPC
+// streams share the same multi-connection, least-loaded client selection as
+// plain HTTP/2 upstreams, and only layers trailer-status surfacing and
+// grpc-timeout handling on top at the NewStream boundary. Message bodies
+// pass through unchanged; they are not re-framed here.
+package grpc
+
+import (
+	"context"
+
+	"github.com/alipay/sofa-mosn/pkg/protocol"
+	str "github.com/alipay/sofa-mosn/pkg/stream"
+	"github.com/alipay/sofa-mosn/pkg/stream/http2"
+	"github.com/alipay/sofa-mosn/pkg/types"
+)
+
+func init() {
+	str.RegisterNewPoolFactory(protocol.GRPC, NewConnPool)
+}
+
+// types.ConnectionPool
+type connPool struct {
+	// delegate does the real connection management: dialing, pooling,
+	// least-loaded selection and idle reaping are all inherited from the
+	// HTTP/2 pool.
+	delegate types.ConnectionPool
+}
+
+func NewConnPool(host types.Host) types.ConnectionPool {
+	return &connPool{
+		delegate: http2.NewConnPool(host),
+	}
+}
+
+func (p *connPool) Protocol() types.Protocol {
+	return protocol.GRPC
+}
+
+func (p *connPool) Host() types.Host {
+	return p.delegate.Host()
+}
+
+func (p *connPool) InitActiveClient(context context.Context) error {
+	return p.delegate.InitActiveClient(context)
+}
+
+func (p *connPool) NewStream(ctx context.Context, streamID string, responseDecoder types.StreamReceiver,
+	cb types.PoolEventListener) types.Cancellable {
+
+	// The http2 pool's NewStream always returns a nil Cancellable - it has
+	// no per-stream handle to reset once OnReady has fired - so the
+	// returned cancellableStream cancels ctx instead. The http2 pool uses
+	// ctx only to pick or dial a connection and does not bind the stream it
+	// opens to it, so cancelling does not reset an open stream and produces
+	// no RST_STREAM or grpc-status CANCELLED.
+	ctx, cancel := context.WithCancel(ctx)
+
+	receiver := &statusReceiver{StreamReceiver: responseDecoder}
+
+	p.delegate.NewStream(ctx, streamID, receiver, &encoderListener{PoolEventListener: cb})
+
+	return &cancellableStream{cancel: cancel}
+}
+
+func (p *connPool) Close() {
+	p.delegate.Close()
+}
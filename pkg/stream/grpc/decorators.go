@@ -0,0 +1,91 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/alipay/sofa-mosn/pkg/types"
+)
+
+// statusReceiver wraps the proxy's types.StreamReceiver so that a trailer
+// HeaderMap carrying grpc-status also carries grpc-message, set to "" when
+// the server omitted it (which the gRPC spec allows on success). Headers
+// passed to OnReceiveHeaders, including the grpc-status of a trailers-only
+// response, are forwarded unchanged.
+type statusReceiver struct {
+	types.StreamReceiver
+}
+
+func (r *statusReceiver) OnReceiveTrailers(ctx context.Context, trailers types.HeaderMap) {
+	if _, ok := trailers.Get(HeaderGRPCStatus); ok {
+		if _, ok := trailers.Get(HeaderGRPCMessage); !ok {
+			trailers.Set(HeaderGRPCMessage, "")
+		}
+	}
+
+	r.StreamReceiver.OnReceiveTrailers(ctx, trailers)
+}
+
+// encoderListener wraps the pool's types.PoolEventListener so the
+// types.StreamEncoder handed back on OnReady is itself wrapped in a
+// frameEncoder, without touching how the underlying connection was
+// selected or dialed.
+type encoderListener struct {
+	types.PoolEventListener
+}
+
+func (l *encoderListener) OnReady(streamID string, encoder types.StreamEncoder, host types.Host) {
+	l.PoolEventListener.OnReady(streamID, &frameEncoder{StreamEncoder: encoder}, host)
+}
+
+// frameEncoder wraps outgoing request headers so a grpc-timeout header is
+// derived from the request context's deadline when the caller hasn't
+// already set one. It does not touch the body: proxied gRPC traffic arrives
+// already framed by the downstream client, so re-framing it here would
+// double-frame it and hand upstream a corrupt length. Only MOSN-originated
+// calls that hand over an unframed payload - the gRPC health checker - need
+// to frame, and they do it themselves before calling AppendData.
+type frameEncoder struct {
+	types.StreamEncoder
+}
+
+func (e *frameEncoder) AppendHeaders(ctx context.Context, headers types.HeaderMap, endStream bool) error {
+	if _, ok := headers.Get(HeaderGRPCTimeout); !ok {
+		if deadline, ok := ctx.Deadline(); ok {
+			headers.Set(HeaderGRPCTimeout, FormatTimeout(time.Until(deadline)))
+		}
+	}
+
+	return e.StreamEncoder.AppendHeaders(ctx, headers, endStream)
+}
+
+// cancellableStream cancels the context the stream was opened with. The
+// HTTP/2 pool does not bind an opened stream to that context, so Cancel does
+// not reset a stream that is already open. Cancel must not call the response
+// receiver itself - the HTTP/2 read goroutine may still be delivering real
+// headers/data/trailers to that same receiver concurrently, and a second,
+// synthesized terminal call would race it.
+type cancellableStream struct {
+	cancel context.CancelFunc
+}
+
+func (c *cancellableStream) Cancel() {
+	c.cancel()
+}
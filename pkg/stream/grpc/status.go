@@ -0,0 +1,66 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"fmt"
+	"time"
+)
+
+// Header names defined by the gRPC over HTTP/2 wire protocol.
+const (
+	HeaderGRPCStatus         = "grpc-status"
+	HeaderGRPCMessage        = "grpc-message"
+	HeaderGRPCTimeout        = "grpc-timeout"
+	HeaderGRPCEncoding       = "grpc-encoding"
+	HeaderGRPCAcceptEncoding = "grpc-accept-encoding"
+)
+
+// Code is a gRPC status code, as defined by
+// https://github.com/grpc/grpc/blob/master/doc/statuscodes.md.
+type Code uint32
+
+const (
+	CodeOK               Code = 0
+	CodeCancelled        Code = 1
+	CodeUnknown          Code = 2
+	CodeInvalidArgument  Code = 3
+	CodeDeadlineExceeded Code = 4
+	CodeUnavailable      Code = 14
+)
+
+// FormatTimeout renders d as a grpc-timeout header value, using the coarsest
+// unit that does not lose precision, as grpc-go's encodeTimeout does.
+func FormatTimeout(d time.Duration) string {
+	switch {
+	case d <= 0:
+		return "0n"
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dH", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dM", d/time.Minute)
+	case d%time.Second == 0:
+		return fmt.Sprintf("%dS", d/time.Second)
+	case d%time.Millisecond == 0:
+		return fmt.Sprintf("%dm", d/time.Millisecond)
+	case d%time.Microsecond == 0:
+		return fmt.Sprintf("%du", d/time.Microsecond)
+	default:
+		return fmt.Sprintf("%dn", d/time.Nanosecond)
+	}
+}
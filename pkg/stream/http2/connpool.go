@@ -19,10 +19,11 @@ package http2
 
 import (
 	"context"
-	"fmt"
 	"net/http"
 	"sync"
+	"time"
 
+	"github.com/alipay/sofa-mosn/pkg/log"
 	"github.com/alipay/sofa-mosn/pkg/protocol"
 	str "github.com/alipay/sofa-mosn/pkg/stream"
 	"github.com/alipay/sofa-mosn/pkg/types"
@@ -32,32 +33,100 @@ import (
 const (
 	// H2 conn key in context
 	H2ConnKey = "h2_conn"
-)
 
-var (
-	connPoolOnce     sync.Once
-	connPoolInstance *connPool
-	transport        *http2.Transport
+	// defaultMaxConns is the number of parallel http2.ClientConns a pool will
+	// keep open to a single host when the cluster does not override it.
+	defaultMaxConns = 8
+
+	// defaultHighWatermark is the fraction of a connection's advertised
+	// SETTINGS_MAX_CONCURRENT_STREAMS above which the pool prefers dialing a
+	// new connection over queuing more streams onto an existing one.
+	defaultHighWatermark = 0.8
+
+	// defaultIdleConnTimeout is how long a connection with zero active
+	// streams is kept around before being reaped, as long as at least one
+	// connection remains for the host.
+	defaultIdleConnTimeout = 90 * time.Second
+
+	// defaultReapInterval is how often the pool sweeps for idle connections.
+	defaultReapInterval = 30 * time.Second
+
+	// defaultDrainTimeout bounds how long a connection that received a
+	// GOAWAY is kept alive waiting for its in-flight streams to finish
+	// before being force-closed.
+	defaultDrainTimeout = 10 * time.Second
 )
 
 // types.ConnectionPool
+// connPool is a per-host pool of one or more http2.ClientConns. It replaces
+// the previous process-wide singleton: every call to NewConnPool gets its own
+// pool, scoped to the host it was created for, and keeps up to MaxConns
+// connections open in parallel, load-balancing new streams across them.
 type connPool struct {
 	activeClients map[string][]*activeClient // key is host:port
-	mux           sync.Mutex
-	host          types.Host
+
+	// drainingClients holds connections that received a GOAWAY: they are
+	// no longer selected for new streams but are kept alive here until
+	// their in-flight streams finish or drainTimeout expires.
+	drainingClients map[string][]*activeClient
+
+	mux       sync.Mutex
+	host      types.Host
+	transport *http2.Transport
+
+	// done is closed by Close() to stop the background reapIdleClients
+	// goroutine; closeOnce guards against Close() being called twice.
+	done      chan struct{}
+	closeOnce sync.Once
+
+	maxConns          int
+	maxStreamsPerConn uint32
+	highWatermark     float64
+	idleConnTimeout   time.Duration
+	drainTimeout      time.Duration
+	proxyProtocol     *types.ProxyProtocolConfig
 }
 
 func NewConnPool(host types.Host) types.ConnectionPool {
-	connPoolOnce.Do(func() {
-		if connPoolInstance == nil {
-			connPoolInstance = &connPool{
-				host:          host,
-				activeClients: make(map[string][]*activeClient),
-			}
+	p := &connPool{
+		host:            host,
+		activeClients:   make(map[string][]*activeClient),
+		drainingClients: make(map[string][]*activeClient),
+		done:            make(chan struct{}),
+
+		maxConns:          defaultMaxConns,
+		maxStreamsPerConn: 0, // 0 means defer to the peer's advertised SETTINGS_MAX_CONCURRENT_STREAMS
+		highWatermark:     defaultHighWatermark,
+		idleConnTimeout:   defaultIdleConnTimeout,
+		drainTimeout:      defaultDrainTimeout,
+	}
+
+	if cfg := host.ClusterInfo().ConnPoolConfig(); cfg != nil {
+		if cfg.MaxConns > 0 {
+			p.maxConns = cfg.MaxConns
 		}
-	})
+		if cfg.MaxConcurrentStreamsPerConn > 0 {
+			p.maxStreamsPerConn = cfg.MaxConcurrentStreamsPerConn
+		}
+		if cfg.HighWatermark > 0 {
+			p.highWatermark = cfg.HighWatermark
+		}
+		if cfg.IdleConnTimeout > 0 {
+			p.idleConnTimeout = cfg.IdleConnTimeout
+		}
+		if cfg.DrainTimeout > 0 {
+			p.drainTimeout = cfg.DrainTimeout
+		}
+		p.proxyProtocol = cfg.ProxyProtocol
+	}
 
-	return connPoolInstance
+	p.transport = &http2.Transport{
+		ConnPool: p,
+	}
+
+	go p.reapIdleClients()
+
+	return p
 }
 
 func (p *connPool) Protocol() types.Protocol {
@@ -88,7 +157,6 @@ func (p *connPool) NewStream(context context.Context, streamID string, responseD
 		p.host.HostStats().UpstreamRequestPendingOverflow.Inc(1)
 		p.host.ClusterInfo().Stats().UpstreamRequestPendingOverflow.Inc(1)
 	} else {
-		ac.totalStream++
 		p.host.HostStats().UpstreamRequestTotal.Inc(1)
 		p.host.HostStats().UpstreamRequestActive.Inc(1)
 		p.host.ClusterInfo().Stats().UpstreamRequestTotal.Inc(1)
@@ -102,6 +170,10 @@ func (p *connPool) NewStream(context context.Context, streamID string, responseD
 }
 
 func (p *connPool) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+	})
+
 	p.mux.Lock()
 	defer p.mux.Unlock()
 
@@ -110,6 +182,11 @@ func (p *connPool) Close() {
 			ac.codecClient.Close()
 		}
 	}
+	for _, acs := range p.drainingClients {
+		for _, ac := range acs {
+			ac.codecClient.Close()
+		}
+	}
 }
 
 func (p *connPool) onConnectionEvent(client *activeClient, event types.ConnectionEvent) {
@@ -125,11 +202,7 @@ func (p *connPool) onConnectionEvent(client *activeClient, event types.Connectio
 			}
 		}
 
-		p.mux.Lock()
-		defer p.mux.Unlock()
-
-		host := client.host.HostInfo.AddressString()
-		delete(p.activeClients, host)
+		p.removeActiveClient(client)
 	} else if event == types.ConnectTimeout {
 		p.host.HostStats().UpstreamRequestTimeout.Inc(1)
 		p.host.ClusterInfo().Stats().UpstreamRequestTimeout.Inc(1)
@@ -144,6 +217,14 @@ func (p *connPool) onStreamDestroy(client *activeClient) {
 	p.host.HostStats().UpstreamRequestActive.Dec(1)
 	p.host.ClusterInfo().Stats().UpstreamRequestActive.Dec(1)
 	p.host.ClusterInfo().ResourceManager().Requests().Decrease()
+
+	p.mux.Lock()
+	draining := client.draining
+	p.mux.Unlock()
+
+	if draining {
+		p.tryFinishDraining(client)
+	}
 }
 
 func (p *connPool) onStreamReset(client *activeClient, reason types.StreamResetReason) {
@@ -160,34 +241,167 @@ func (p *connPool) onStreamReset(client *activeClient, reason types.StreamResetR
 	}
 }
 
-func (p *connPool) onGoAway(client *activeClient) {
+// onGoAway moves client out of selection and into the draining set: its
+// in-flight streams are left to finish naturally, and NewStream calls that
+// would otherwise have picked it now dial or reuse a different connection.
+// lastStreamID is the GOAWAY frame's own Last-Stream-ID, as reported by
+// str.CodecClientCallbacks.OnGoAway, and is recorded on client so callers can
+// tell which of its streams the peer definitely never processed.
+func (p *connPool) onGoAway(client *activeClient, lastStreamID uint64) {
 	p.host.HostStats().UpstreamConnectionCloseNotify.Inc(1)
 	p.host.ClusterInfo().Stats().UpstreamConnectionCloseNotify.Inc(1)
 
+	p.mux.Lock()
+
+	host := client.host.HostInfo.AddressString()
+	acs := p.activeClients[host]
+
+	for i, ac := range acs {
+		if ac == client {
+			p.activeClients[host] = append(acs[:i], acs[i+1:]...)
+			break
+		}
+	}
+
+	client.draining = true
+	client.lastStreamID = lastStreamID
+	client.drainDeadline = time.Now().Add(p.drainTimeout)
+	p.drainingClients[host] = append(p.drainingClients[host], client)
+
+	p.mux.Unlock()
+
+	time.AfterFunc(p.drainTimeout, func() {
+		p.forceFinishDraining(client)
+	})
+}
+
+func (p *connPool) removeActiveClient(client *activeClient) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
 
 	host := client.host.HostInfo.AddressString()
-	delete(p.activeClients, host)
+
+	if acs := p.activeClients[host]; len(acs) > 0 {
+		for i, ac := range acs {
+			if ac == client {
+				p.activeClients[host] = append(acs[:i], acs[i+1:]...)
+				break
+			}
+		}
+	}
+
+	p.removeDrainingClientLocked(host, client)
+}
+
+// tryFinishDraining closes client and drops it from the draining set once
+// it has no more active streams.
+func (p *connPool) tryFinishDraining(client *activeClient) {
+	if client.h2Conn.State().StreamsActive > 0 {
+		return
+	}
+
+	p.finishDraining(client)
+}
+
+// forceFinishDraining closes client once its drain timeout has elapsed,
+// regardless of whether it still has in-flight streams.
+func (p *connPool) forceFinishDraining(client *activeClient) {
+	p.mux.Lock()
+	stillDraining := client.draining
+	p.mux.Unlock()
+
+	if stillDraining {
+		p.finishDraining(client)
+	}
+}
+
+// finishDraining closes client, but only if it is the caller that actually
+// removed it from drainingClients - tryFinishDraining and forceFinishDraining
+// can race to drain the same client once its stream count drops to zero
+// right as its drain timeout fires, and only one of them must call Close().
+func (p *connPool) finishDraining(client *activeClient) {
+	p.mux.Lock()
+	host := client.host.HostInfo.AddressString()
+	removed := p.removeDrainingClientLocked(host, client)
+	p.mux.Unlock()
+
+	if removed {
+		client.codecClient.Close()
+	}
+}
+
+// removeDrainingClientLocked removes client from drainingClients[host],
+// reporting whether it was found there. Must be called with p.mux held.
+func (p *connPool) removeDrainingClientLocked(host string, client *activeClient) bool {
+	acs := p.drainingClients[host]
+
+	for i, ac := range acs {
+		if ac == client {
+			p.drainingClients[host] = append(acs[:i], acs[i+1:]...)
+			client.draining = false
+			return true
+		}
+	}
+
+	return false
 }
 
 func (p *connPool) createCodecClient(context context.Context, connData types.CreateConnectionData) str.CodecClient {
 	return str.NewCodecClient(context, protocol.HTTP2, connData.Connection, connData.HostInfo)
 }
 
-// Http2 connpool interface
+// loadScore reports how loaded ac is, in [0, 1], based on its active stream
+// count against the peer's advertised SETTINGS_MAX_CONCURRENT_STREAMS, capped
+// by capOverride when the cluster configures a stricter per-connection limit.
+// Lower is less loaded.
+func (ac *activeClient) loadScore(capOverride uint32) float64 {
+	state := ac.h2Conn.State()
+
+	max := state.MaxConcurrentStreams
+	if capOverride > 0 && capOverride < max {
+		max = capOverride
+	}
+	if max == 0 {
+		max = 1
+	}
+
+	score := float64(state.StreamsActive) / float64(max)
+	if score > 1 {
+		score = 1
+	}
+
+	return score
+}
+
+// getOrInitActiveClient selects the least-loaded usable connection for addr,
+// dialing a new one when there isn't one yet, the pool has room and every
+// existing connection is at or above the high watermark.
 func (p *connPool) getOrInitActiveClient(context context.Context, addr string) *activeClient {
 	p.mux.Lock()
 
+	var best *activeClient
+	bestScore := 2.0 // above any real loadScore, so the first candidate always wins
+	usable := 0
+
 	for _, ac := range p.activeClients[addr] {
-		if ac.h2Conn.CanTakeNewRequest() {
-			p.mux.Unlock()
+		if !ac.h2Conn.CanTakeNewRequest() {
+			continue
+		}
+
+		usable++
 
-			return ac
+		if score := ac.loadScore(p.maxStreamsPerConn); score < bestScore {
+			best, bestScore = ac, score
 		}
 	}
 
-	// If connection's stream id is out of bound, closed or 'go away', make a new one
+	if best != nil && (bestScore < p.highWatermark || usable >= p.maxConns) {
+		p.mux.Unlock()
+		return best
+	}
+
+	// Either there is no usable connection yet, or every connection is past
+	// the high watermark and the pool still has room for another one.
 	if nac := newActiveClient(context, p); nac != nil {
 		p.activeClients[addr] = append(p.activeClients[addr], nac)
 		p.mux.Unlock()
@@ -197,7 +411,50 @@ func (p *connPool) getOrInitActiveClient(context context.Context, addr string) *
 
 	p.mux.Unlock()
 
-	return nil
+	return best
+}
+
+// reapIdleClients periodically closes connections that have had zero active
+// streams for longer than idleConnTimeout, keeping at least one connection
+// per host alive.
+func (p *connPool) reapIdleClients() {
+	ticker := time.NewTicker(defaultReapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		p.mux.Lock()
+		var toClose []*activeClient
+
+		for addr, acs := range p.activeClients {
+			if len(acs) <= 1 {
+				continue
+			}
+
+			kept := acs[:0]
+			closed := 0
+			for _, ac := range acs {
+				idle := ac.h2Conn.State().StreamsActive == 0
+				if idle && time.Since(ac.lastActive) > p.idleConnTimeout && closed < len(acs)-1 {
+					toClose = append(toClose, ac)
+					closed++
+					continue
+				}
+				kept = append(kept, ac)
+			}
+			p.activeClients[addr] = kept
+		}
+		p.mux.Unlock()
+
+		for _, ac := range toClose {
+			ac.codecClient.Close()
+		}
+	}
 }
 
 // GetClientConn
@@ -225,12 +482,22 @@ func (p *connPool) MarkDead(http2Conn *http2.ClientConn) {
 		}
 	}
 
-	fmt.Printf("MarkDead %s, %d \n", acsIdx, acIdx)
-
 	if acsIdx != "" && acIdx > -1 {
 		p.activeClients[acsIdx] = append(p.activeClients[acsIdx][:acIdx],
 			p.activeClients[acsIdx][acIdx+1:]...)
+		return
+	}
+
+	for host, acs := range p.drainingClients {
+		for _, ac := range acs {
+			if ac.h2Conn == http2Conn {
+				p.removeDrainingClientLocked(host, ac)
+				return
+			}
+		}
 	}
+
+	log.DefaultLogger.Debugf("http2 conn pool: MarkDead called for a connection not tracked in activeClients or drainingClients")
 }
 
 // stream.CodecClientCallbacks
@@ -242,13 +509,33 @@ type activeClient struct {
 	codecClient        str.CodecClient
 	h2Conn             *http2.ClientConn
 	host               types.CreateConnectionData
-	totalStream        uint64
 	closeWithActiveReq bool
+
+	// lastActive is read and written from multiple goroutines
+	// (OnStreamDestroy, reapIdleClients) and must only be touched with
+	// pool.mux held.
+	lastActive time.Time
+
+	// draining, lastStreamID and drainDeadline are set by onGoAway and
+	// only ever read/written with pool.mux held.
+	draining      bool
+	lastStreamID  uint64
+	drainDeadline time.Time
+}
+
+// LastStreamID reports the highest stream id the peer had acted on when
+// this client's GOAWAY was received, straight from the frame's
+// Last-Stream-ID field. Streams above it are "definitely not processed" and
+// safe for the retry layer to resend as-is, per RFC 7540 §6.8; streams at or
+// below it are "unknown" and must not be blindly retried.
+func (ac *activeClient) LastStreamID() uint64 {
+	return ac.lastStreamID
 }
 
 func newActiveClient(ctx context.Context, pool *connPool) *activeClient {
 	ac := &activeClient{
-		pool: pool,
+		pool:       pool,
+		lastActive: time.Now(),
 	}
 
 	data := pool.host.CreateConnection(ctx)
@@ -257,13 +544,13 @@ func newActiveClient(ctx context.Context, pool *connPool) *activeClient {
 		return nil
 	}
 
-	if transport == nil {
-		transport = &http2.Transport{
-			ConnPool: connPoolInstance,
+	if pool.proxyProtocol != nil {
+		if err := writeProxyProtocolHeader(ctx, pool.proxyProtocol, data.Connection.RawConn()); err != nil {
+			return nil
 		}
 	}
 
-	h2Conn, err := transport.NewClientConn(data.Connection.RawConn())
+	h2Conn, err := pool.transport.NewClientConn(data.Connection.RawConn())
 
 	if err != nil {
 		return nil
@@ -300,6 +587,10 @@ func (ac *activeClient) OnEvent(event types.ConnectionEvent) {
 }
 
 func (ac *activeClient) OnStreamDestroy() {
+	ac.pool.mux.Lock()
+	ac.lastActive = time.Now()
+	ac.pool.mux.Unlock()
+
 	ac.pool.onStreamDestroy(ac)
 }
 
@@ -307,6 +598,9 @@ func (ac *activeClient) OnStreamReset(reason types.StreamResetReason) {
 	ac.pool.onStreamReset(ac, reason)
 }
 
-func (ac *activeClient) OnGoAway() {
-	ac.pool.onGoAway(ac)
+// OnGoAway is str.CodecClientCallbacks' GOAWAY notification, extended to
+// carry the frame's Last-Stream-ID field through to the pool so draining
+// clients can expose it via LastStreamID.
+func (ac *activeClient) OnGoAway(lastStreamID uint64) {
+	ac.pool.onGoAway(ac, lastStreamID)
 }
@@ -0,0 +1,156 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/alipay/sofa-mosn/pkg/types"
+)
+
+// Well-known v2 TLV types, as defined by the PROXY protocol spec.
+const (
+	TLVTypeALPN      byte = 0x01
+	TLVTypeAuthority byte = 0x02
+	TLVTypeSSL       byte = 0x20
+)
+
+// downstreamConnKey is the context key under which ContextWithDownstreamConn
+// stores the downstream net.Conn. It is unexported: callers must go through
+// ContextWithDownstreamConn / DownstreamConnFromContext rather than poking
+// the context directly, so a setter and a reader in different packages can't
+// drift apart on the key itself.
+type downstreamConnKey struct{}
+
+// ContextWithDownstreamConn returns a copy of ctx carrying conn as the
+// downstream connection a new upstream connection is being opened on behalf
+// of. The proxy layer must call this when building the context it passes to
+// ConnectionPool.NewStream, so that writeProxyProtocolHeader has a real
+// connection to read src/dst addresses from; without it, PROXY protocol
+// headers are silently never written.
+func ContextWithDownstreamConn(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, downstreamConnKey{}, conn)
+}
+
+// DownstreamConnFromContext returns the downstream net.Conn stored by
+// ContextWithDownstreamConn, if any.
+func DownstreamConnFromContext(ctx context.Context) (net.Conn, bool) {
+	conn, ok := ctx.Value(downstreamConnKey{}).(net.Conn)
+	return conn, ok
+}
+
+var proxyProtocolV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+// writeProxyProtocolHeader writes a PROXY protocol header carrying the
+// downstream client's real src/dst addresses to rawConn, before any HTTP/2
+// preface is exchanged on it. It is a no-op if the downstream connection
+// isn't available on ctx (e.g. a health check probe, which has no real
+// downstream client to describe).
+func writeProxyProtocolHeader(ctx context.Context, cfg *types.ProxyProtocolConfig, rawConn net.Conn) error {
+	downstream, ok := DownstreamConnFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	header, err := buildProxyProtocolHeader(cfg, downstream.RemoteAddr(), downstream.LocalAddr())
+	if err != nil {
+		return err
+	}
+
+	_, err = rawConn.Write(header)
+	return err
+}
+
+// buildProxyProtocolHeader renders the PROXY protocol header to write ahead
+// of the HTTP/2 preface, describing a connection from src to dst.
+func buildProxyProtocolHeader(cfg *types.ProxyProtocolConfig, src, dst net.Addr) ([]byte, error) {
+	srcTCP, ok1 := src.(*net.TCPAddr)
+	dstTCP, ok2 := dst.(*net.TCPAddr)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("proxy protocol: non-TCP address %T/%T", src, dst)
+	}
+
+	switch cfg.Version {
+	case types.ProxyProtocolV1:
+		return encodeProxyProtocolV1(srcTCP, dstTCP), nil
+	case types.ProxyProtocolV2:
+		return encodeProxyProtocolV2(srcTCP, dstTCP, cfg.TLVs), nil
+	default:
+		return nil, fmt.Errorf("proxy protocol: unsupported version %q", cfg.Version)
+	}
+}
+
+// encodeProxyProtocolV1 renders the human-readable v1 header, e.g.
+// "PROXY TCP4 192.0.2.1 198.51.100.1 35000 443\r\n".
+func encodeProxyProtocolV1(src, dst *net.TCPAddr) []byte {
+	family := "TCP4"
+	if src.IP.To4() == nil {
+		family = "TCP6"
+	}
+
+	return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, src.IP.String(), dst.IP.String(), src.Port, dst.Port))
+}
+
+// encodeProxyProtocolV2 renders the binary v2 header: 12-byte signature,
+// version/command, family/protocol, address block length, the address
+// block itself and any trailing TLVs.
+func encodeProxyProtocolV2(src, dst *net.TCPAddr, tlvs []types.ProxyProtocolTLV) []byte {
+	var addrBlock []byte
+	var addressFamily byte
+
+	if v4src, v4dst := src.IP.To4(), dst.IP.To4(); v4src != nil && v4dst != nil {
+		addressFamily = 0x11 // AF_INET, STREAM
+		addrBlock = make([]byte, 12)
+		copy(addrBlock[0:4], v4src)
+		copy(addrBlock[4:8], v4dst)
+		binary.BigEndian.PutUint16(addrBlock[8:10], uint16(src.Port))
+		binary.BigEndian.PutUint16(addrBlock[10:12], uint16(dst.Port))
+	} else {
+		addressFamily = 0x21 // AF_INET6, STREAM
+		addrBlock = make([]byte, 36)
+		copy(addrBlock[0:16], src.IP.To16())
+		copy(addrBlock[16:32], dst.IP.To16())
+		binary.BigEndian.PutUint16(addrBlock[32:34], uint16(src.Port))
+		binary.BigEndian.PutUint16(addrBlock[34:36], uint16(dst.Port))
+	}
+
+	var tlvBytes []byte
+	for _, tlv := range tlvs {
+		tlvBytes = append(tlvBytes, tlv.Type)
+		length := make([]byte, 2)
+		binary.BigEndian.PutUint16(length, uint16(len(tlv.Value)))
+		tlvBytes = append(tlvBytes, length...)
+		tlvBytes = append(tlvBytes, tlv.Value...)
+	}
+
+	header := make([]byte, 0, len(proxyProtocolV2Signature)+2+2+len(addrBlock)+len(tlvBytes))
+	header = append(header, proxyProtocolV2Signature...)
+	header = append(header, 0x21) // version 2, command PROXY
+	header = append(header, addressFamily)
+
+	length := make([]byte, 2)
+	binary.BigEndian.PutUint16(length, uint16(len(addrBlock)+len(tlvBytes)))
+	header = append(header, length...)
+	header = append(header, addrBlock...)
+	header = append(header, tlvBytes...)
+
+	return header
+}
@@ -0,0 +1,95 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http2
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/alipay/sofa-mosn/pkg/types"
+)
+
+// fakeConn is a minimal net.Conn that records what's written to it, without
+// touching a real socket.
+type fakeConn struct {
+	net.Conn
+	local, remote net.Addr
+	written       [][]byte
+}
+
+func (c *fakeConn) Write(b []byte) (int, error) {
+	c.written = append(c.written, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func (c *fakeConn) LocalAddr() net.Addr  { return c.local }
+func (c *fakeConn) RemoteAddr() net.Addr { return c.remote }
+
+func newFakeTCPConn(remoteIP string, remotePort int, localIP string, localPort int) *fakeConn {
+	return &fakeConn{
+		remote: &net.TCPAddr{IP: net.ParseIP(remoteIP), Port: remotePort},
+		local:  &net.TCPAddr{IP: net.ParseIP(localIP), Port: localPort},
+	}
+}
+
+func TestWriteProxyProtocolHeader_NoDownstreamConn(t *testing.T) {
+	raw := &fakeConn{}
+	cfg := &types.ProxyProtocolConfig{Version: types.ProxyProtocolV1}
+
+	if err := writeProxyProtocolHeader(context.Background(), cfg, raw); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(raw.written) != 0 {
+		t.Fatalf("expected no write when ctx carries no downstream conn, got %v", raw.written)
+	}
+}
+
+func TestWriteProxyProtocolHeader_WritesBeforePreface(t *testing.T) {
+	downstream := newFakeTCPConn("192.0.2.1", 35000, "198.51.100.1", 443)
+	ctx := ContextWithDownstreamConn(context.Background(), downstream)
+
+	raw := &fakeConn{}
+	cfg := &types.ProxyProtocolConfig{Version: types.ProxyProtocolV1}
+
+	if err := writeProxyProtocolHeader(ctx, cfg, raw); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// newActiveClient always writes the PROXY header to rawConn before
+	// handing it to pool.transport.NewClientConn, which sends the H2
+	// preface as a separate Write. Reproduce that ordering here.
+	preface := []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+	if _, err := raw.Write(preface); err != nil {
+		t.Fatalf("unexpected error writing preface: %v", err)
+	}
+
+	if len(raw.written) != 2 {
+		t.Fatalf("expected exactly 2 writes (proxy header, then preface), got %d", len(raw.written))
+	}
+
+	want := "PROXY TCP4 192.0.2.1 198.51.100.1 35000 443\r\n"
+	if got := string(raw.written[0]); got != want {
+		t.Fatalf("proxy protocol header = %q, want %q", got, want)
+	}
+
+	if got := string(raw.written[1]); got != string(preface) {
+		t.Fatalf("second write = %q, want the H2 preface %q", got, preface)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import "time"
+
+// ConnPoolConfig is the cluster-level `conn_pool` config block: it tunes how
+// a cluster's connection pool (e.g. pkg/stream/http2's per-host pool) dials
+// and load-balances across connections to a single host. ClusterInfo.
+// ConnPoolConfig returns nil when a cluster doesn't configure this block, in
+// which case pool implementations fall back to their own defaults.
+type ConnPoolConfig struct {
+	// MaxConns caps how many connections a pool keeps open to one host.
+	MaxConns int `json:"max_conns,omitempty"`
+
+	// MaxConcurrentStreamsPerConn lowers the per-connection stream capacity
+	// that the pool's load scoring and HighWatermark use, when it is below
+	// the SETTINGS_MAX_CONCURRENT_STREAMS the peer advertises. 0 means use
+	// the peer's advertised value. It is not a hard limit: when the pool
+	// dials no new connection (for example because it already has MaxConns
+	// usable ones), new streams still go to a connection at or past it.
+	MaxConcurrentStreamsPerConn uint32 `json:"max_concurrent_streams_per_conn,omitempty"`
+
+	// HighWatermark is the fraction of a connection's stream capacity above
+	// which the pool prefers dialing a new connection over queuing more
+	// streams onto an existing one.
+	HighWatermark float64 `json:"high_watermark,omitempty"`
+
+	// IdleConnTimeout is how long a connection with zero active streams is
+	// kept around before being reaped, as long as at least one connection
+	// remains for the host.
+	IdleConnTimeout time.Duration `json:"idle_conn_timeout,omitempty"`
+
+	// DrainTimeout bounds how long a connection that received a GOAWAY is
+	// kept alive waiting for its in-flight streams to finish before being
+	// force-closed.
+	DrainTimeout time.Duration `json:"drain_timeout,omitempty"`
+
+	// ProxyProtocol, when set, makes the pool write a PROXY protocol header
+	// in front of the transport preface on every new upstream connection
+	// whose dialing context carries a downstream connection (see
+	// ContextWithDownstreamConn in pkg/stream/http2). Connections dialed
+	// without one, such as health check probes, get no header.
+	ProxyProtocol *ProxyProtocolConfig `json:"proxy_protocol,omitempty"`
+}
+
+// ProxyProtocolVersion selects which PROXY protocol wire format a pool
+// writes to upstream connections.
+type ProxyProtocolVersion string
+
+const (
+	ProxyProtocolV1 ProxyProtocolVersion = "v1"
+	ProxyProtocolV2 ProxyProtocolVersion = "v2"
+)
+
+// ProxyProtocolTLV is a single type-length-value entry appended to a v2
+// PROXY protocol header, e.g. ALPN or authority.
+type ProxyProtocolTLV struct {
+	Type  byte   `json:"type"`
+	Value []byte `json:"value"`
+}
+
+// ProxyProtocolConfig is the `proxy_protocol` block of a cluster's conn_pool
+// config. It lives in this package, rather than alongside the pool
+// implementations that consume it (e.g. pkg/stream/http2), so that both
+// ClusterInfo and those pool implementations can depend on it without
+// either importing the other.
+type ProxyProtocolConfig struct {
+	Version ProxyProtocolVersion `json:"version"`
+	TLVs    []ProxyProtocolTLV   `json:"tlvs,omitempty"`
+}
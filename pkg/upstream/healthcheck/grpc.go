@@ -0,0 +1,283 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package healthcheck implements active health checking sessions for
+// upstream hosts.
+package healthcheck
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/alipay/sofa-mosn/pkg/log"
+	"github.com/alipay/sofa-mosn/pkg/protocol"
+	"github.com/alipay/sofa-mosn/pkg/stream/grpc"
+	"github.com/alipay/sofa-mosn/pkg/types"
+	"github.com/golang/protobuf/proto"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func init() {
+	RegisterSessionFactory("grpc_health_check", newGRPCHealthCheckSession)
+}
+
+const (
+	healthCheckPath = "/grpc.health.v1.Health/Check"
+
+	defaultHealthyThreshold   = 2
+	defaultUnhealthyThreshold = 2
+	defaultTimeout            = time.Second
+	defaultInterval           = 15 * time.Second
+)
+
+// GRPCHealthCheckConfig is the `grpc_health_check` block of a cluster's
+// health_check config, mirroring grpc.health.v1.Health/Check semantics the
+// same way Envoy's grpc_health_check does.
+type GRPCHealthCheckConfig struct {
+	// ServiceName is sent as HealthCheckRequest.service. Empty means the
+	// overall server health, per the grpc.health.v1.Health contract.
+	ServiceName string `json:"service_name,omitempty"`
+
+	// DisableConnectionReuse sends every probe over a pool created just for
+	// that probe and closed after it, instead of the pool the session keeps
+	// open across probes. Either pool is the health checker's own, built by
+	// grpc.NewConnPool for the host; the proxy's pools for the host are not
+	// shared. Connection reuse is the default - ReuseConnection can't be
+	// the field that spells that out, since its bool zero value is false -
+	// so this is phrased as an opt-out instead.
+	DisableConnectionReuse bool `json:"disable_connection_reuse,omitempty"`
+
+	Interval           time.Duration `json:"interval,omitempty"`
+	Timeout            time.Duration `json:"timeout,omitempty"`
+	HealthyThreshold   uint32        `json:"healthy_threshold,omitempty"`
+	UnhealthyThreshold uint32        `json:"unhealthy_threshold,omitempty"`
+}
+
+// grpcHealthCheckSession drives one host's probes. It is a
+// types.HealthCheckSession: CheckHealth sends a Health/Check RPC and reports
+// whether the host answered SERVING.
+type grpcHealthCheckSession struct {
+	host   types.Host
+	config GRPCHealthCheckConfig
+
+	// pool is kept open across probes unless DisableConnectionReuse is set,
+	// so successive checks reuse whichever activeClient the HTTP/2 pool
+	// already has open for this host instead of dialing a fresh one.
+	pool types.ConnectionPool
+
+	// consecutiveFailures counts probes failed in a row since the last
+	// success. recordFailure only bumps the ejection stat the moment this
+	// crosses UnhealthyThreshold, so the stat reflects actual ejections
+	// rather than every individual failed probe.
+	consecutiveFailures uint32
+}
+
+func newGRPCHealthCheckSession(host types.Host, rawConfig interface{}) types.HealthCheckSession {
+	config, ok := rawConfig.(GRPCHealthCheckConfig)
+	if !ok {
+		log.DefaultLogger.Errorf("grpc_health_check: invalid config %#v, falling back to defaults", rawConfig)
+	}
+
+	if config.HealthyThreshold == 0 {
+		config.HealthyThreshold = defaultHealthyThreshold
+	}
+	if config.UnhealthyThreshold == 0 {
+		config.UnhealthyThreshold = defaultUnhealthyThreshold
+	}
+	if config.Timeout == 0 {
+		config.Timeout = defaultTimeout
+	}
+	if config.Interval == 0 {
+		config.Interval = defaultInterval
+	}
+
+	s := &grpcHealthCheckSession{host: host, config: config}
+	if !config.DisableConnectionReuse {
+		s.pool = grpc.NewConnPool(host)
+	}
+
+	return s
+}
+
+// CheckHealth sends a single grpc.health.v1.Health/Check RPC and reports
+// whether the host should be considered healthy. A SERVING response is
+// healthy; NOT_SERVING, a non-OK gRPC status, an RST_STREAM or a timeout are
+// all treated as failures and drive ejection through the host's existing
+// failure stats.
+func (s *grpcHealthCheckSession) CheckHealth() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+	defer cancel()
+
+	pool := s.pool
+	if pool == nil {
+		pool = grpc.NewConnPool(s.host)
+		defer pool.Close()
+	}
+
+	payload, err := proto.Marshal(&healthpb.HealthCheckRequest{Service: s.config.ServiceName})
+	if err != nil {
+		return s.recordFailure()
+	}
+
+	resp, err := doUnaryCall(ctx, pool, healthCheckPath, payload)
+	if err != nil {
+		if ctx.Err() != nil {
+			// The deadline firing is accounted for by OnTimeout, which the
+			// framework calls on the same timeout; recording it again here
+			// would double-count one timed-out probe as two failures.
+			return false
+		}
+		return s.recordFailure()
+	}
+
+	status := &healthpb.HealthCheckResponse{}
+	if err := proto.Unmarshal(resp, status); err != nil {
+		return s.recordFailure()
+	}
+
+	if status.Status != healthpb.HealthCheckResponse_SERVING {
+		return s.recordFailure()
+	}
+
+	s.consecutiveFailures = 0
+	return true
+}
+
+// recordFailure tracks one more probe failing in a row and bumps the
+// ejection stat only on the probe that actually crosses UnhealthyThreshold,
+// so the stat counts ejections rather than every failed probe.
+func (s *grpcHealthCheckSession) recordFailure() bool {
+	s.consecutiveFailures++
+	if s.consecutiveFailures == s.config.UnhealthyThreshold {
+		s.host.HostStats().UpstreamRequestFailureEject.Inc(1)
+		s.host.ClusterInfo().Stats().UpstreamRequestFailureEject.Inc(1)
+	}
+
+	return false
+}
+
+func (s *grpcHealthCheckSession) OnTimeout() {
+	s.recordFailure()
+}
+
+func (s *grpcHealthCheckSession) Release() {
+	if s.pool != nil {
+		s.pool.Close()
+	}
+}
+
+// unaryResult carries the outcome of a single unary gRPC call back to the
+// goroutine that issued it.
+type unaryResult struct {
+	body []byte
+	err  error
+}
+
+// doUnaryCall drives a single request/response gRPC stream over pool,
+// returning the decoded message body or an error describing why the RPC
+// didn't complete successfully (non-OK grpc-status, stream reset, timeout).
+func doUnaryCall(ctx context.Context, pool types.ConnectionPool, path string, payload []byte) ([]byte, error) {
+	results := make(chan unaryResult, 1)
+
+	receiver := &unaryReceiver{results: results}
+	listener := &unaryListener{ctx: ctx, path: path, payload: payload, pool: pool, results: results}
+
+	pool.NewStream(ctx, "", receiver, listener)
+
+	select {
+	case res := <-results:
+		return res.body, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// unaryListener sends the request headers and framed body as soon as the
+// pool hands back a ready stream.
+type unaryListener struct {
+	ctx     context.Context
+	path    string
+	payload []byte
+	pool    types.ConnectionPool
+	results chan unaryResult
+}
+
+func (l *unaryListener) OnReady(streamID string, encoder types.StreamEncoder, host types.Host) {
+	headers := protocol.CommonHeader(map[string]string{
+		":method":      "POST",
+		":path":        l.path,
+		"content-type": "application/grpc",
+		"te":           "trailers",
+	})
+
+	if err := encoder.AppendHeaders(l.ctx, headers, false); err != nil {
+		l.results <- unaryResult{err: err}
+		return
+	}
+
+	// The health checker is the message's origin, not a pass-through proxy,
+	// so it - not the encoder - is responsible for gRPC length-prefix framing
+	// the payload before handing it to AppendData.
+	framed := grpc.EncodeFrame(false, l.payload)
+	if err := encoder.AppendData(l.ctx, types.NewIoBufferBytes(framed), true); err != nil {
+		l.results <- unaryResult{err: err}
+	}
+}
+
+func (l *unaryListener) OnFailure(streamID string, reason types.PoolFailureReason, host types.Host) {
+	l.results <- unaryResult{err: errors.New("grpc health check: " + string(reason))}
+}
+
+// unaryReceiver collects the single response message and interprets the
+// grpc-status trailer once the stream completes.
+type unaryReceiver struct {
+	results chan unaryResult
+	body    []byte
+}
+
+func (r *unaryReceiver) OnReceiveHeaders(ctx context.Context, headers types.HeaderMap, endStream bool) {
+	if endStream {
+		r.finish(headers)
+	}
+}
+
+func (r *unaryReceiver) OnReceiveData(ctx context.Context, data types.IoBuffer, endStream bool) {
+	if _, length, err := grpc.DecodeFrameHeader(data.Bytes()); err == nil && int(length) <= data.Len()-5 {
+		r.body = append(r.body, data.Bytes()[5:5+length]...)
+	}
+}
+
+func (r *unaryReceiver) OnReceiveTrailers(ctx context.Context, trailers types.HeaderMap) {
+	r.finish(trailers)
+}
+
+func (r *unaryReceiver) finish(statusHeaders types.HeaderMap) {
+	code := "0"
+	if v, ok := statusHeaders.Get(grpc.HeaderGRPCStatus); ok {
+		code = v
+	}
+
+	if code != strconv.Itoa(int(grpc.CodeOK)) {
+		message, _ := statusHeaders.Get(grpc.HeaderGRPCMessage)
+		r.results <- unaryResult{err: errors.New("grpc health check failed: status " + code + " " + message)}
+		return
+	}
+
+	r.results <- unaryResult{body: r.body}
+}
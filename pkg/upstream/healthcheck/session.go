@@ -0,0 +1,60 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package healthcheck
+
+import (
+	"sync"
+
+	"github.com/alipay/sofa-mosn/pkg/types"
+)
+
+// SessionFactory builds a types.HealthCheckSession for host, given the
+// raw, protocol-specific config parsed out of the cluster's health_check
+// block (e.g. GRPCHealthCheckConfig for "grpc_health_check").
+type SessionFactory func(host types.Host, config interface{}) types.HealthCheckSession
+
+var (
+	sessionFactoriesMutex sync.RWMutex
+	sessionFactories      = make(map[string]SessionFactory)
+)
+
+// RegisterSessionFactory associates a health_check config key (e.g.
+// "grpc_health_check") with the factory that builds its session, so the
+// cluster manager can construct the right kind of health checker without
+// knowing about every protocol's package.
+func RegisterSessionFactory(name string, factory SessionFactory) {
+	sessionFactoriesMutex.Lock()
+	defer sessionFactoriesMutex.Unlock()
+
+	sessionFactories[name] = factory
+}
+
+// NewSession looks up the session factory registered under name and uses it
+// to build a health check session for host. It returns nil if name wasn't
+// registered by any protocol's health checker.
+func NewSession(name string, host types.Host, config interface{}) types.HealthCheckSession {
+	sessionFactoriesMutex.RLock()
+	factory := sessionFactories[name]
+	sessionFactoriesMutex.RUnlock()
+
+	if factory == nil {
+		return nil
+	}
+
+	return factory(host, config)
+}